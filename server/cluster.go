@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// Leader election is implemented on top of the plugin KV store, which is
+// already replicated across the cluster: every node running the election
+// loop periodically tries to acquire (or renew) a TTL-backed lock, and the
+// node currently holding it is the leader responsible for actually emitting
+// monitoring reports. A node starts the loop only once it handles its first
+// `subscribe report` or `status` command (see ensureLeaderElection). This
+// avoids every node in a multi-node Mattermost cluster running the same
+// ticker and posting duplicate reports.
+const (
+	leaderKey              = "cluster-leader"
+	leaderTTL              = 15 * time.Second
+	leaderTickInterval     = 5 * time.Second
+	leaderTransferAttempts = 3
+	leaderTransferBackoff  = 500 * time.Millisecond
+)
+
+// leaderElection tracks whether the local node currently holds the cluster
+// leader lock. onAcquire is called every time the lock is successfully
+// acquired or renewed (not just on a leader/follower transition), so a
+// caller can use it to periodically reconcile state that depends on being
+// the leader; onLose is called once, when the node stops being the leader.
+type leaderElection struct {
+	api plugin.API
+	id  string
+
+	onAcquire func()
+	onLose    func()
+
+	mu       sync.Mutex
+	isLeader bool
+	stop     chan struct{}
+}
+
+func newLeaderElection(api plugin.API, onAcquire, onLose func()) *leaderElection {
+	return &leaderElection{
+		api:       api,
+		id:        model.NewId(),
+		onAcquire: onAcquire,
+		onLose:    onLose,
+	}
+}
+
+// run starts the leader election loop. It returns immediately; the loop runs
+// until stop is called.
+func (le *leaderElection) run() {
+	le.mu.Lock()
+	if le.stop != nil {
+		le.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	le.stop = stop
+	le.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(leaderTickInterval)
+		defer ticker.Stop()
+
+		le.tryAcquire()
+
+		for {
+			select {
+			case <-ticker.C:
+				le.tryAcquire()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (le *leaderElection) tryAcquire() {
+	record := fmt.Sprintf("%s|%d", le.id, time.Now().Add(leaderTTL).UnixNano())
+
+	old, err := le.api.KVGet(leaderKey)
+	if err != nil {
+		le.api.LogError("KVGet", logErrorKey, err)
+		return
+	}
+
+	owner, expiry := parseLeaderRecord(old)
+
+	switch {
+	case old == nil || time.Now().UnixNano() > expiry:
+		ok, err := le.api.KVCompareAndSet(leaderKey, old, []byte(record))
+		if err != nil {
+			le.api.LogError("KVCompareAndSet", logErrorKey, err)
+			return
+		}
+		if ok {
+			le.becomeLeader()
+		}
+	case owner == le.id:
+		if ok, err := le.api.KVCompareAndSet(leaderKey, old, []byte(record)); err != nil {
+			le.api.LogError("KVCompareAndSet", logErrorKey, err)
+		} else if ok {
+			le.becomeLeader()
+		}
+	default:
+		le.becomeFollower()
+	}
+}
+
+func (le *leaderElection) becomeLeader() {
+	le.mu.Lock()
+	le.isLeader = true
+	le.mu.Unlock()
+
+	if le.onAcquire != nil {
+		le.onAcquire()
+	}
+}
+
+func (le *leaderElection) becomeFollower() {
+	le.mu.Lock()
+	wasLeader := le.isLeader
+	le.isLeader = false
+	le.mu.Unlock()
+
+	if wasLeader && le.onLose != nil {
+		le.onLose()
+	}
+}
+
+func (le *leaderElection) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.isLeader
+}
+
+// resign gives up leadership ahead of a graceful shutdown, retrying a few
+// times so another node can pick up the lock without waiting for the full
+// TTL to expire. If every attempt fails, the lock is left in place and
+// normal TTL-based election takes over once it expires.
+func (le *leaderElection) resign() {
+	le.mu.Lock()
+	isLeader := le.isLeader
+	le.mu.Unlock()
+
+	if !isLeader {
+		return
+	}
+
+	old, err := le.api.KVGet(leaderKey)
+	if err != nil {
+		le.api.LogError("KVGet", logErrorKey, err)
+		return
+	}
+
+	for attempt := 1; attempt <= leaderTransferAttempts; attempt++ {
+		le.api.LogInfo("attempting leadership transfer", "attempt", attempt)
+
+		ok, err := le.api.KVCompareAndSet(leaderKey, old, nil)
+		if err != nil {
+			le.api.LogError("KVCompareAndSet", logErrorKey, err)
+		} else if ok {
+			le.becomeFollower()
+			return
+		}
+
+		time.Sleep(leaderTransferBackoff)
+	}
+
+	le.api.LogWarn("leadership transfer failed after retries; falling back to normal election")
+}
+
+func (le *leaderElection) Close() {
+	le.mu.Lock()
+	stop := le.stop
+	le.stop = nil
+	le.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+
+	le.resign()
+}
+
+// OnDeactivate is called by the Mattermost server as this plugin is shutting
+// down. It gives up cluster leadership (see leaderElection.resign) so another
+// node can pick it up immediately, instead of leaving every other node
+// waiting out the full leaderTTL.
+func (p *Plugin) OnDeactivate() error {
+	if le != nil {
+		le.Close()
+	}
+	return nil
+}
+
+func parseLeaderRecord(b []byte) (owner string, expiryUnixNano int64) {
+	if b == nil {
+		return "", 0
+	}
+
+	parts := strings.SplitN(string(b), "|", 2)
+	if len(parts) != 2 {
+		return "", 0
+	}
+
+	var expiry int64
+	if _, err := fmt.Sscanf(parts[1], "%d", &expiry); err != nil {
+		return "", 0
+	}
+
+	return parts[0], expiry
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -13,8 +11,6 @@ import (
 
 type commandHandlerFunc func(p *Plugin, channelID string, parameters []string) string
 
-// TODO(DanielSz50): implement get-current-limits command
-
 const (
 	logErrorKey = "error"
 
@@ -41,18 +37,11 @@ const (
 )
 
 func getLogsLimit(api plugin.API) (int, error) {
-	b, err := api.KVGet(logsLimitKey)
+	v, err := logsLimitSetting.current(api)
 	if err != nil {
-		return 0, fmt.Errorf("api.KVGet: %w", err)
+		return 0, err
 	}
-
-	var limit int
-
-	if err := json.Unmarshal(b, &limit); err != nil {
-		return 0, fmt.Errorf("json.Unmarshal: %w", err)
-	}
-
-	return limit, nil
+	return v.(int), nil
 }
 
 func (p *Plugin) setLogsLimit(parameters []string) string {
@@ -60,24 +49,8 @@ func (p *Plugin) setLogsLimit(parameters []string) string {
 		return "You must supply exactly one parameter (integer value)."
 	}
 
-	i, err := strconv.Atoi(parameters[0])
-	if err != nil {
-		p.API.LogError("Atoi", logErrorKey, err)
-		return settingLogsLimitUnsuccessful
-	}
-
-	if i <= 0 {
-		return "Invalid argument - logs limit must be a positive integer."
-	}
-
-	b, err := json.Marshal(i)
-	if err != nil {
-		p.API.LogError("Marshal", logErrorKey, err)
-		return settingLogsLimitUnsuccessful
-	}
-
-	if err := p.API.KVSet(logsLimitKey, b); err != nil {
-		p.API.LogError("KVSet", logErrorKey, err)
+	if err := logsLimitSetting.set(p.API, parameters[0]); err != nil {
+		p.API.LogError("set", logErrorKey, err)
 		return settingLogsLimitUnsuccessful
 	}
 
@@ -89,18 +62,11 @@ func setLogsLimit(p *Plugin, channelID string, parameters []string) string {
 }
 
 func getLogsStartTime(api plugin.API) (time.Duration, error) {
-	b, err := api.KVGet(logsStartTimeKey)
+	v, err := logsStartTimeSetting.current(api)
 	if err != nil {
-		return 0, fmt.Errorf("api.KVGet: %w", err)
+		return 0, err
 	}
-
-	var seconds int64
-
-	if err := json.Unmarshal(b, &seconds); err != nil {
-		return 0, fmt.Errorf("json.Unmarshal: %w", err)
-	}
-
-	return time.Duration(seconds) * time.Second, nil
+	return time.Duration(v.(int64)) * time.Second, nil
 }
 
 func (p *Plugin) setLogsStartTime(parameters []string) string {
@@ -108,24 +74,8 @@ func (p *Plugin) setLogsStartTime(parameters []string) string {
 		return "You must supply exactly one parameter (number of seconds)."
 	}
 
-	i, err := strconv.ParseInt(parameters[0], 10, 64)
-	if err != nil {
-		p.API.LogError("ParseInt", logErrorKey, err)
-		return settingLogsStartTimeUnsuccessful
-	}
-
-	if i <= 0 {
-		return "Invalid argument - start time must be a positive integer."
-	}
-
-	b, err := json.Marshal(i)
-	if err != nil {
-		p.API.LogError("Marshal", logErrorKey, err)
-		return settingLogsStartTimeUnsuccessful
-	}
-
-	if err := p.API.KVSet(logsStartTimeKey, b); err != nil {
-		p.API.LogError("KVSet", logErrorKey, err)
+	if err := logsStartTimeSetting.set(p.API, parameters[0]); err != nil {
+		p.API.LogError("set", logErrorKey, err)
 		return settingLogsStartTimeUnsuccessful
 	}
 
@@ -155,6 +105,21 @@ func gettingLogsUnsuccessfulMessage(message string) string {
 	return fmt.Sprintf("%s: %s", gettingLogsUnsuccessful, message)
 }
 
+// gettingLogsError turns a p.query error into the user-facing message,
+// distinguishing a deadline exceeded and an admin-issued cancel-queries from
+// a generic Nagios-side failure.
+func gettingLogsError(p *Plugin, err error) string {
+	switch err {
+	case errTimedOut:
+		return gettingLogsTimedOut
+	case errCancelled:
+		return gettingLogsCancelled
+	default:
+		p.API.LogError("query", logErrorKey, err)
+		return gettingLogsUnsuccessful
+	}
+}
+
 func unknownParameterMessage(parameter string) string {
 	return fmt.Sprintf("Unknown parameter (%s).", parameter)
 }
@@ -294,9 +259,8 @@ func (p *Plugin) getLogs(parameters []string) string {
 			},
 		}
 		var alerts nagios.AlertList
-		if err := p.client.Query(q, &alerts); err != nil {
-			p.API.LogError("Query", logErrorKey, err)
-			return gettingLogsUnsuccessful
+		if err := p.query(q, &alerts); err != nil {
+			return gettingLogsError(p, err)
 		}
 		return formatAlerts(alerts)
 	case "notifications":
@@ -313,9 +277,8 @@ func (p *Plugin) getLogs(parameters []string) string {
 			},
 		}
 		var notifications nagios.NotificationList
-		if err := p.client.Query(q, &notifications); err != nil {
-			p.API.LogError("Query", logErrorKey, err)
-			return gettingLogsUnsuccessful
+		if err := p.query(q, &notifications); err != nil {
+			return gettingLogsError(p, err)
 		}
 		return formatNotifications(notifications)
 	default:
@@ -328,18 +291,11 @@ func getLogs(p *Plugin, channelID string, parameters []string) string {
 }
 
 func getReportFrequency(api plugin.API) (time.Duration, error) {
-	b, err := api.KVGet(reportFrequencyKey)
+	v, err := reportFrequencySetting.current(api)
 	if err != nil {
-		return 0, fmt.Errorf("api.KVGet: %w", err)
-	}
-
-	var minutes int
-
-	if err := json.Unmarshal(b, &minutes); err != nil {
-		return 0, fmt.Errorf("json.Unmarshal: %w", err)
+		return 0, err
 	}
-
-	return time.Duration(minutes) * time.Minute, nil
+	return time.Duration(v.(int)) * time.Minute, nil
 }
 
 func (p *Plugin) setReportFrequency(parameters []string) string {
@@ -347,24 +303,8 @@ func (p *Plugin) setReportFrequency(parameters []string) string {
 		return "You must supply exactly one parameter (number of minutes)."
 	}
 
-	i, err := strconv.Atoi(parameters[0])
-	if err != nil {
-		p.API.LogError("Atoi", logErrorKey, err)
-		return settingReportFrequencyUnsuccessful
-	}
-
-	if i <= 0 {
-		return "Invalid argument - report frequency must be a positive integer."
-	}
-
-	b, err := json.Marshal(i)
-	if err != nil {
-		p.API.LogError("Marshal", logErrorKey, err)
-		return settingReportFrequencyUnsuccessful
-	}
-
-	if err := p.API.KVSet(reportFrequencyKey, b); err != nil {
-		p.API.LogError("KVSet", logErrorKey, err)
+	if err := reportFrequencySetting.set(p.API, parameters[0]); err != nil {
+		p.API.LogError("set", logErrorKey, err)
 		return settingReportFrequencyUnsuccessful
 	}
 
@@ -375,68 +315,22 @@ func setReportFrequency(p *Plugin, channelID string, parameters []string) string
 	return p.setReportFrequency(parameters)
 }
 
-// func getReportChannel(api plugin.API) (string, error) {
-// 	b, err := api.KVGet(reportChannelKey)
-// 	if err != nil {
-// 		return "", fmt.Errorf("api.KVGet: %w", err)
-// 	}
-//
-// 	if b == nil {
-// 		return "", nil
-// 	}
-//
-// 	var channel string
-//
-// 	if err := json.Unmarshal(b, &channel); err != nil {
-// 		return "", fmt.Errorf("json.Unmarshal: %w", err)
-// 	}
-//
-// 	return channel, nil
-// }
-
-func setReportChannel(api plugin.API, channelID string) string {
-	b, err := json.Marshal(channelID)
-	if err != nil {
-		api.LogError("Marshal", logErrorKey, err)
+func setReportChannel(p *Plugin, channelID string) string {
+	if err := addChannelToSet(p.API, reportChannelKey, channelID); err != nil {
+		p.API.LogError("addChannelToSet", logErrorKey, err)
 		return settingReportChannelUnsuccessful
 	}
 
-	if err := api.KVSet(reportChannelKey, b); err != nil {
-		api.LogError("KVSet", logErrorKey, err)
-		return settingReportChannelUnsuccessful
+	if ensureLeaderElection(p).IsLeader() {
+		subs.start(p, channelID)
 	}
 
 	return "Subscribed to system monitoring report successfully."
 }
 
-func getChangesChannel(api plugin.API) (string, error) {
-	b, err := api.KVGet(changesChannelKey)
-	if err != nil {
-		return "", fmt.Errorf("api.KVGet: %w", err)
-	}
-
-	if b == nil {
-		return "", nil
-	}
-
-	var channel string
-
-	if err := json.Unmarshal(b, &channel); err != nil {
-		return "", fmt.Errorf("json.Unmarshal: %w", err)
-	}
-
-	return channel, nil
-}
-
 func setChangesChannel(api plugin.API, channelID string) string {
-	b, err := json.Marshal(channelID)
-	if err != nil {
-		api.LogError("Marshal", logErrorKey, err)
-		return settingChangesChannelUnsuccessful
-	}
-
-	if err := api.KVSet(changesChannelKey, b); err != nil {
-		api.LogError("KVSet", logErrorKey, err)
+	if err := addChannelToSet(api, changesChannelKey, channelID); err != nil {
+		api.LogError("addChannelToSet", logErrorKey, err)
 		return settingChangesChannelUnsuccessful
 	}
 
@@ -450,14 +344,7 @@ func (p *Plugin) subscribe(channelID string, parameters []string) string {
 
 	switch parameters[0] {
 	case "report":
-		// TODO(amwolff): rewrite it to support HA (should be quick).
-		stop := make(chan bool, 1)
-
-		go p.addMonitoringReport(channelID, stop)
-
-		p.subscriptionStop = stop
-
-		return setReportChannel(p.API, channelID)
+		return setReportChannel(p, channelID)
 	case "configuration-changes":
 		return setChangesChannel(p.API, channelID)
 	default:
@@ -469,7 +356,7 @@ func subscribe(p *Plugin, channelID string, parameters []string) string {
 	return p.subscribe(channelID, parameters)
 }
 
-func (p *Plugin) unsubscribe(parameters []string) string {
+func (p *Plugin) unsubscribe(channelID string, parameters []string) string {
 	if len(parameters) != 1 {
 		return "You must supply exactly one parameter (report|configuration-changes)."
 	}
@@ -478,16 +365,15 @@ func (p *Plugin) unsubscribe(parameters []string) string {
 
 	switch parameters[0] {
 	case "report":
-		// TODO(amwolff): rewrite it to support HA (should be quick).
-		p.subscriptionStop <- true
+		subs.stop(channelID)
 
-		if err := p.API.KVDelete(reportChannelKey); err != nil {
-			p.API.LogError("KVDelete", logErrorKey, err)
+		if err := removeChannelFromSet(p.API, reportChannelKey, channelID); err != nil {
+			p.API.LogError("removeChannelFromSet", logErrorKey, err)
 			return unsubscribingUnsuccessful
 		}
 	case "configuration-changes":
-		if err := p.API.KVDelete(changesChannelKey); err != nil {
-			p.API.LogError("KVDelete", logErrorKey, err)
+		if err := removeChannelFromSet(p.API, changesChannelKey, channelID); err != nil {
+			p.API.LogError("removeChannelFromSet", logErrorKey, err)
 			return unsubscribingUnsuccessful
 		}
 	default:
@@ -498,5 +384,24 @@ func (p *Plugin) unsubscribe(parameters []string) string {
 }
 
 func unsubscribe(p *Plugin, channelID string, parameters []string) string {
-	return p.unsubscribe(parameters)
+	return p.unsubscribe(channelID, parameters)
+}
+
+// status reports this node's role in the cluster leader election and, when
+// it's the leader, which channels it's currently emitting reports for.
+func (p *Plugin) status() string {
+	if ensureLeaderElection(p).IsLeader() {
+		running := subs.running()
+		if len(running) == 0 {
+			return "This node is the cluster leader (no active report subscriptions)."
+		}
+		return fmt.Sprintf("This node is the cluster leader, emitting reports for: %s.",
+			strings.Join(running, ", "))
+	}
+
+	return "This node is a follower; the cluster leader is emitting reports."
+}
+
+func status(p *Plugin, channelID string, parameters []string) string {
+	return p.status()
 }
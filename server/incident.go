@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/ulumuri/go-nagios/nagios"
+)
+
+// Incident ticket integration opens a ticket in an external tracker whenever
+// a HARD CRITICAL host/service alert is observed, and posts a link back to
+// every channel subscribed to monitoring reports.
+const (
+	incidentBackendsKey = "incident-backends"
+
+	settingIncidentBackendUnsuccessful = "Configuring incident backend unsuccessful."
+
+	// incidentDedupTTL bounds how long a flapping host/service CRITICAL
+	// alert is suppressed from opening another ticket.
+	incidentDedupTTL = time.Hour
+
+	incidentBackendJira    = "jira"
+	incidentBackendGitHub  = "github"
+	incidentBackendWebhook = "webhook"
+
+	// cgiURLKey is the base URL of the Nagios Core CGIs (e.g.
+	// https://nagios.example.com/cgi-bin), used to build permalinks in
+	// incident tickets.
+	cgiURLKey = "nagios-cgi-url"
+)
+
+// IncidentCreator opens a ticket in an external tracker and returns a link
+// back to it. Each supported backend implements it independently so new
+// backends can be added without touching the alert-handling path. ctx bounds
+// the outbound HTTP call the same way it does for p.client.Query, so a hung
+// tracker endpoint can't wedge the periodic report goroutine this runs on.
+type IncidentCreator interface {
+	CreateIncident(ctx context.Context, summary, description string) (link string, err error)
+}
+
+// incidentBackendConfig is stored as-is under incidentBackendsKey; only the
+// fields relevant to Type are populated.
+type incidentBackendConfig struct {
+	Type string `json:"type"`
+
+	JiraBaseURL   string `json:"jiraBaseURL,omitempty"`
+	JiraProject   string `json:"jiraProject,omitempty"`
+	JiraIssueType string `json:"jiraIssueType,omitempty"`
+	JiraUser      string `json:"jiraUser,omitempty"`
+	JiraToken     string `json:"jiraToken,omitempty"`
+
+	GitHubRepo  string `json:"gitHubRepo,omitempty"`
+	GitHubToken string `json:"gitHubToken,omitempty"`
+
+	WebhookURL string `json:"webhookURL,omitempty"`
+}
+
+func getIncidentBackend(api plugin.API) (incidentBackendConfig, error) {
+	b, err := api.KVGet(incidentBackendsKey)
+	if err != nil {
+		return incidentBackendConfig{}, fmt.Errorf("api.KVGet: %w", err)
+	}
+
+	if b == nil {
+		return incidentBackendConfig{}, nil
+	}
+
+	var cfg incidentBackendConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return incidentBackendConfig{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func setIncidentBackend(api plugin.API, cfg incidentBackendConfig) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := api.KVSet(incidentBackendsKey, b); err != nil {
+		return fmt.Errorf("api.KVSet: %w", err)
+	}
+
+	return nil
+}
+
+func (c incidentBackendConfig) creator(httpClient *http.Client) (IncidentCreator, error) {
+	switch c.Type {
+	case incidentBackendJira:
+		return &jiraIncidentCreator{cfg: c, httpClient: httpClient}, nil
+	case incidentBackendGitHub:
+		return &gitHubIncidentCreator{cfg: c, httpClient: httpClient}, nil
+	case incidentBackendWebhook:
+		return &webhookIncidentCreator{cfg: c, httpClient: httpClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown incident backend type (%s)", c.Type)
+	}
+}
+
+type jiraIncidentCreator struct {
+	cfg        incidentBackendConfig
+	httpClient *http.Client
+}
+
+func (j *jiraIncidentCreator) CreateIncident(ctx context.Context, summary, description string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": j.cfg.JiraProject},
+			"summary":     summary,
+			"description": description,
+			"issuetype":   map[string]string{"name": j.cfg.JiraIssueType},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(j.cfg.JiraBaseURL, "/")+"/rest/api/2/issue", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(j.cfg.JiraUser, j.cfg.JiraToken)
+
+	resp, err := j.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("httpClient.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("jira: unexpected status %s", resp.Status)
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("json.Decode: %w", err)
+	}
+
+	return strings.TrimRight(j.cfg.JiraBaseURL, "/") + "/browse/" + created.Key, nil
+}
+
+type gitHubIncidentCreator struct {
+	cfg        incidentBackendConfig
+	httpClient *http.Client
+}
+
+func (g *gitHubIncidentCreator) CreateIncident(ctx context.Context, summary, description string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"title": summary,
+		"body":  description,
+	})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("https://api.github.com/repos/%s/issues", g.cfg.GitHubRepo), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "token "+g.cfg.GitHubToken)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("httpClient.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("github: unexpected status %s", resp.Status)
+	}
+
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("json.Decode: %w", err)
+	}
+
+	return created.HTMLURL, nil
+}
+
+type webhookIncidentCreator struct {
+	cfg        incidentBackendConfig
+	httpClient *http.Client
+}
+
+func (w *webhookIncidentCreator) CreateIncident(ctx context.Context, summary, description string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"summary":     summary,
+		"description": description,
+	})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("httpClient.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+
+	// A generic webhook has no canonical ticket to link back to, so we link
+	// to the endpoint itself.
+	return w.cfg.WebhookURL, nil
+}
+
+func incidentDedupKey(hostName, serviceDescription, state string) string {
+	return fmt.Sprintf("incident-dedup-%s-%s-%s", hostName, serviceDescription, state)
+}
+
+func (p *Plugin) incidentAlreadyOpened(hostName, serviceDescription, state string) (bool, error) {
+	b, err := p.API.KVGet(incidentDedupKey(hostName, serviceDescription, state))
+	if err != nil {
+		return false, fmt.Errorf("api.KVGet: %w", err)
+	}
+	return b != nil, nil
+}
+
+func (p *Plugin) incidentMarkOpened(hostName, serviceDescription, state string) error {
+	if err := p.API.KVSetWithExpiry(
+		incidentDedupKey(hostName, serviceDescription, state),
+		[]byte("1"),
+		int64(incidentDedupTTL.Seconds()),
+	); err != nil {
+		return fmt.Errorf("api.KVSetWithExpiry: %w", err)
+	}
+	return nil
+}
+
+// cgiPermalink builds a link to the Nagios Core status CGI detail page for
+// hostName (and, if given, serviceDescription), rooted at the configured
+// nagios-cgi-url setting.
+func cgiPermalink(cgiURL, hostName, serviceDescription string) string {
+	if cgiURL == "" {
+		return ""
+	}
+
+	base := strings.TrimRight(cgiURL, "/")
+
+	if serviceDescription == "" {
+		return fmt.Sprintf("%s/status.cgi?host=%s", base, url.QueryEscape(hostName))
+	}
+
+	return fmt.Sprintf("%s/extinfo.cgi?type=2&host=%s&service=%s",
+		base, url.QueryEscape(hostName), url.QueryEscape(serviceDescription))
+}
+
+// incidentTimeoutContext bounds an IncidentCreator.CreateIncident call the
+// same way p.query bounds a Nagios query, reusing the configured connect and
+// query timeouts rather than introducing a separate setting.
+func (p *Plugin) incidentTimeoutContext() (context.Context, context.CancelFunc) {
+	queryTimeout, err := getQueryTimeout(p.API)
+	if err != nil {
+		p.API.LogError("getQueryTimeout", logErrorKey, err)
+		queryTimeout = defaultQueryTimeout * time.Second
+	}
+
+	connectTimeout, err := getConnectTimeout(p.API)
+	if err != nil {
+		p.API.LogError("getConnectTimeout", logErrorKey, err)
+		connectTimeout = defaultConnectTimeout * time.Second
+	}
+
+	return context.WithTimeout(context.Background(), connectTimeout+queryTimeout)
+}
+
+// maybeCreateIncident opens a ticket for e if it's a HARD CRITICAL alert, an
+// incident backend is configured, and one hasn't already been opened for the
+// same host/service/state within incidentDedupTTL. It fires independent of
+// alert routing, since routing is opt-in and most deployments never
+// configure a route; the link is posted to every channel subscribed to
+// monitoring reports.
+func (p *Plugin) maybeCreateIncident(e nagios.AlertListEntry) {
+	if e.StateType != "HARD" || e.State != "CRITICAL" {
+		return
+	}
+
+	hostName := formatHostName(e.HostName, e.Name)
+
+	opened, err := p.incidentAlreadyOpened(hostName, e.Description, e.State)
+	if err != nil {
+		p.API.LogError("incidentAlreadyOpened", logErrorKey, err)
+		return
+	}
+	if opened {
+		return
+	}
+
+	cfg, err := getIncidentBackend(p.API)
+	if err != nil {
+		p.API.LogError("getIncidentBackend", logErrorKey, err)
+		return
+	}
+	if cfg.Type == "" {
+		return
+	}
+
+	creator, err := cfg.creator(http.DefaultClient)
+	if err != nil {
+		p.API.LogError("creator", logErrorKey, err)
+		return
+	}
+
+	cgiURL, err := cgiURLSetting.current(p.API)
+	if err != nil {
+		p.API.LogError("current", logErrorKey, err)
+		cgiURL = cgiURLSetting.defaultVal
+	}
+
+	summary := fmt.Sprintf("%s %s %s %s", hostName, e.Description, e.StateType, e.State)
+	description := fmt.Sprintf("%s\n\n%s", e.PluginOutput, cgiPermalink(cgiURL.(string), hostName, e.Description))
+
+	ctx, cancel := p.incidentTimeoutContext()
+	defer cancel()
+
+	link, err := creator.CreateIncident(ctx, summary, description)
+	if err != nil {
+		p.API.LogError("CreateIncident", logErrorKey, err)
+		return
+	}
+
+	if err := p.incidentMarkOpened(hostName, e.Description, e.State); err != nil {
+		p.API.LogError("incidentMarkOpened", logErrorKey, err)
+	}
+
+	channelIDs, err := getReportChannels(p.API)
+	if err != nil {
+		p.API.LogError("getReportChannels", logErrorKey, err)
+		return
+	}
+
+	for _, channelID := range channelIDs {
+		p.postToChannel(channelID, fmt.Sprintf("Opened incident for %s: %s", summary, link))
+	}
+}
+
+// Cheat sheet:
+//
+// [command] [action]    [parameters...]
+// incident  configure    <type> <key>=<value>...
+//
+// Recognized keys by type:
+// jira:    baseURL, project, issueType, user, token
+// github:  repo, token
+// webhook: url
+
+func parseIncidentConfig(backendType string, parameters []string) (incidentBackendConfig, error) {
+	cfg := incidentBackendConfig{Type: backendType}
+
+	for _, parameter := range parameters {
+		kv := strings.SplitN(parameter, "=", 2)
+		if len(kv) != 2 {
+			return incidentBackendConfig{}, fmt.Errorf("invalid key=value pair (%s)", parameter)
+		}
+
+		switch backendType {
+		case incidentBackendJira:
+			switch kv[0] {
+			case "baseURL":
+				cfg.JiraBaseURL = kv[1]
+			case "project":
+				cfg.JiraProject = kv[1]
+			case "issueType":
+				cfg.JiraIssueType = kv[1]
+			case "user":
+				cfg.JiraUser = kv[1]
+			case "token":
+				cfg.JiraToken = kv[1]
+			default:
+				return incidentBackendConfig{}, fmt.Errorf("unknown jira config field (%s)", kv[0])
+			}
+		case incidentBackendGitHub:
+			switch kv[0] {
+			case "repo":
+				cfg.GitHubRepo = kv[1]
+			case "token":
+				cfg.GitHubToken = kv[1]
+			default:
+				return incidentBackendConfig{}, fmt.Errorf("unknown github config field (%s)", kv[0])
+			}
+		case incidentBackendWebhook:
+			switch kv[0] {
+			case "url":
+				cfg.WebhookURL = kv[1]
+			default:
+				return incidentBackendConfig{}, fmt.Errorf("unknown webhook config field (%s)", kv[0])
+			}
+		default:
+			return incidentBackendConfig{}, fmt.Errorf("unknown incident backend type (%s)", backendType)
+		}
+	}
+
+	return cfg, nil
+}
+
+func (p *Plugin) incidentConfigure(parameters []string) string {
+	if len(parameters) < 1 {
+		return "You must supply a backend type (jira|github|webhook)."
+	}
+
+	cfg, err := parseIncidentConfig(parameters[0], parameters[1:])
+	if err != nil {
+		p.API.LogError("parseIncidentConfig", logErrorKey, err)
+		return settingIncidentBackendUnsuccessful
+	}
+
+	if err := setIncidentBackend(p.API, cfg); err != nil {
+		p.API.LogError("setIncidentBackend", logErrorKey, err)
+		return settingIncidentBackendUnsuccessful
+	}
+
+	return "Incident backend configured successfully."
+}
+
+func (p *Plugin) incident(parameters []string) string {
+	if len(parameters) == 0 {
+		return "You must supply at least one parameter (configure)."
+	}
+
+	switch parameters[0] {
+	case "configure":
+		return p.incidentConfigure(parameters[1:])
+	default:
+		return unknownParameterMessage(parameters[0])
+	}
+}
+
+func incident(p *Plugin, channelID string, parameters []string) string {
+	return p.incident(parameters)
+}
+
+func (p *Plugin) setCGIURL(parameters []string) string {
+	if len(parameters) != 1 {
+		return "You must supply exactly one parameter (the Nagios CGI base URL)."
+	}
+
+	if err := cgiURLSetting.set(p.API, parameters[0]); err != nil {
+		p.API.LogError("set", logErrorKey, err)
+		return "Setting CGI URL unsuccessful."
+	}
+
+	return "CGI URL set successfully."
+}
+
+func setCGIURL(p *Plugin, channelID string, parameters []string) string {
+	return p.setCGIURL(parameters)
+}
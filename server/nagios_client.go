@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ulumuri/go-nagios/nagios"
+)
+
+// nagiosClient issues queries directly against the Nagios Core JSON CGIs
+// (statusjson.cgi/archivejson.cgi) using http.NewRequestWithContext, so the
+// context p.query builds from the configured connect/query timeouts (and an
+// admin-issued `/nagios cancel-queries`) bounds the outbound HTTP request
+// itself, rather than just p.query's wait on it.
+type nagiosClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newNagiosClient(baseURL string, httpClient *http.Client) *nagiosClient {
+	return &nagiosClient{baseURL: baseURL, httpClient: httpClient}
+}
+
+// queryValues converts q into the URL query parameters the Nagios Core JSON
+// CGI API expects. It only needs to support the request types this plugin
+// actually issues.
+func queryValues(q nagios.Query) (url.Values, error) {
+	switch r := q.(type) {
+	case nagios.AlertListRequest:
+		return generalRequestValues("alertlist", alertRequestValues(r.GeneralAlertRequest)), nil
+	case nagios.NotificationListRequest:
+		return generalRequestValues("notificationlist", notificationRequestValues(r.GeneralNotificationRequest)), nil
+	default:
+		return nil, fmt.Errorf("unsupported query type (%T)", q)
+	}
+}
+
+// generalRequestValues adds the query name to the values built from either
+// nagios.GeneralAlertRequest or nagios.GeneralNotificationRequest, which
+// share the same shape.
+func generalRequestValues(queryName string, values url.Values) url.Values {
+	values.Set("query", queryName)
+	return values
+}
+
+func alertRequestValues(r nagios.GeneralAlertRequest) url.Values {
+	return commonRequestValues(r.FormatOptions, r.Count, r.HostName, r.ServiceDescription, r.StartTime, r.EndTime)
+}
+
+func notificationRequestValues(r nagios.GeneralNotificationRequest) url.Values {
+	return commonRequestValues(r.FormatOptions, r.Count, r.HostName, r.ServiceDescription, r.StartTime, r.EndTime)
+}
+
+func commonRequestValues(formatOptions nagios.FormatOptions, count int, hostName, serviceDescription string, startTime, endTime int64) url.Values {
+	values := url.Values{}
+
+	if formatOptions.Enumerate {
+		values.Set("formatoptions", "enumerate")
+	}
+	if count != 0 {
+		values.Set("count", strconv.Itoa(count))
+	}
+	if hostName != "" {
+		values.Set("hostname", hostName)
+	}
+	if serviceDescription != "" {
+		values.Set("servicedescription", serviceDescription)
+	}
+	values.Set("starttime", strconv.FormatInt(startTime, 10))
+	values.Set("endtime", strconv.FormatInt(endTime, 10))
+
+	return values
+}
+
+// Query runs q against the Nagios Core JSON CGI API and decodes the
+// response into v.
+func (c *nagiosClient) Query(ctx context.Context, q nagios.Query, v interface{}) error {
+	values, err := queryValues(q)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"?"+values.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("httpClient.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("json.Decode: %w", err)
+	}
+
+	return nil
+}
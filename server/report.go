@@ -0,0 +1,91 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ulumuri/go-nagios/nagios"
+)
+
+// addMonitoringReport runs on its own goroutine for a single channel
+// subscribed to system monitoring reports (see setReportChannel), emitting a
+// report on a ticker until stop receives a value. The report frequency is
+// re-read from settings on every tick, so a running subscription picks up a
+// setReportFrequency change without needing to be restarted.
+func (p *Plugin) addMonitoringReport(channelID string, stop chan bool) {
+	since := time.Now()
+
+	for {
+		frequency, err := getReportFrequency(p.API)
+		if err != nil {
+			p.API.LogError("getReportFrequency", logErrorKey, err)
+			frequency = defaultReportFrequency * time.Minute
+		}
+
+		select {
+		case <-time.After(frequency):
+			until := time.Now()
+			p.emitMonitoringReport(channelID, since, until)
+			since = until
+		case <-stop:
+			return
+		}
+	}
+}
+
+// emitMonitoringReport queries alerts and notifications raised in
+// [since, until), posts each to channelID, and runs it through
+// routeAlert/routeNotification so any configured alert route - and, for
+// alerts, maybeCreateIncident - gets a chance to act on it too.
+func (p *Plugin) emitMonitoringReport(channelID string, since, until time.Time) {
+	alerts, err := p.queryAlertsSince(since, until)
+	if err != nil {
+		p.API.LogError("queryAlertsSince", logErrorKey, err)
+	} else if alerts.Result.TypeText != resultTypeTextSuccess {
+		p.API.LogError("queryAlertsSince", logErrorKey, alerts.Result.TypeText)
+	} else {
+		for _, e := range alerts.Data.AlertList {
+			p.postToChannel(channelID, formatAlertListEntry(e))
+			p.routeAlert(e)
+		}
+	}
+
+	notifications, err := p.queryNotificationsSince(since, until)
+	if err != nil {
+		p.API.LogError("queryNotificationsSince", logErrorKey, err)
+	} else if notifications.Result.TypeText != resultTypeTextSuccess {
+		p.API.LogError("queryNotificationsSince", logErrorKey, notifications.Result.TypeText)
+	} else {
+		for _, e := range notifications.Data.NotificationList {
+			p.postToChannel(channelID, formatNotificationListEntry(e))
+			p.routeNotification(e)
+		}
+	}
+}
+
+func (p *Plugin) queryAlertsSince(since, until time.Time) (nagios.AlertList, error) {
+	q := nagios.AlertListRequest{
+		GeneralAlertRequest: nagios.GeneralAlertRequest{
+			FormatOptions: nagios.FormatOptions{Enumerate: true},
+			StartTime:     since.Unix(),
+			EndTime:       until.Unix(),
+		},
+	}
+
+	var alerts nagios.AlertList
+	err := p.query(q, &alerts)
+	return alerts, err
+}
+
+func (p *Plugin) queryNotificationsSince(since, until time.Time) (nagios.NotificationList, error) {
+	q := nagios.NotificationListRequest{
+		GeneralNotificationRequest: nagios.GeneralNotificationRequest{
+			FormatOptions: nagios.FormatOptions{Enumerate: true},
+			StartTime:     since.Unix(),
+			EndTime:       until.Unix(),
+		},
+	}
+
+	var notifications nagios.NotificationList
+	err := p.query(q, &notifications)
+	return notifications, err
+}
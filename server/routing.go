@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/ulumuri/go-nagios/nagios"
+)
+
+// Alert routing lets teams fan incoming Nagios alerts and notifications out
+// to more channels, based on rules they define themselves, in addition to
+// the channels subscribed through report-channel/changes-channel.
+const (
+	alertRoutesKey = "alert-routes"
+
+	settingAlertRouteUnsuccessful = "Setting alert route unsuccessful."
+)
+
+// alertMatch describes which alerts/notifications a route applies to. Empty
+// fields are treated as wildcards. HostName and ServiceGlob are matched as
+// shell globs (see path.Match), so "db-*" matches "db-01", "db-02", etc.
+// State and StateType are only checked against alerts and NotificationType
+// only against notifications; the other kind of entry ignores them.
+type alertMatch struct {
+	HostName         string `json:"hostName,omitempty"`
+	ServiceGlob      string `json:"serviceGlob,omitempty"`
+	State            string `json:"state,omitempty"`
+	StateType        string `json:"stateType,omitempty"`
+	NotificationType string `json:"notificationType,omitempty"`
+}
+
+type alertRoute struct {
+	Match     alertMatch `json:"match"`
+	ChannelID string     `json:"channelID"`
+
+	// MinSeverity, if set, requires an alert's state to be at least this
+	// severe (see alertSeverityRank) for the route to match. It has no
+	// effect on notification routing.
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// alertSeverityRank orders Nagios service states from least to most severe,
+// so MinSeverity can be compared rather than string-matched. Host states (UP,
+// DOWN, UNREACHABLE) are not ranked, so a host alert never meets a non-empty
+// MinSeverity. It only
+// applies to alerts: NotificationListEntry carries no state of its own, so a
+// route's MinSeverity has no effect on notification matching.
+var alertSeverityRank = map[string]int{
+	"OK":       0,
+	"WARNING":  1,
+	"UNKNOWN":  2,
+	"CRITICAL": 3,
+}
+
+// meetsMinSeverity reports whether state is at least as severe as
+// minSeverity. An empty minSeverity always matches; an unrecognized state or
+// minSeverity never does.
+func meetsMinSeverity(state, minSeverity string) bool {
+	if minSeverity == "" {
+		return true
+	}
+
+	stateRank, ok := alertSeverityRank[strings.ToUpper(state)]
+	if !ok {
+		return false
+	}
+
+	minRank, ok := alertSeverityRank[strings.ToUpper(minSeverity)]
+	if !ok {
+		return false
+	}
+
+	return stateRank >= minRank
+}
+
+func getAlertRoutes(api plugin.API) ([]alertRoute, error) {
+	b, err := api.KVGet(alertRoutesKey)
+	if err != nil {
+		return nil, fmt.Errorf("api.KVGet: %w", err)
+	}
+
+	if b == nil {
+		return nil, nil
+	}
+
+	var routes []alertRoute
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return routes, nil
+}
+
+func setAlertRoutes(api plugin.API, routes []alertRoute) error {
+	b, err := json.Marshal(routes)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := api.KVSet(alertRoutesKey, b); err != nil {
+		return fmt.Errorf("api.KVSet: %w", err)
+	}
+
+	return nil
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	ok, err := path.Match(pattern, value)
+	if err != nil {
+		return strings.EqualFold(pattern, value)
+	}
+
+	return ok
+}
+
+func (m alertMatch) matchesAlert(e nagios.AlertListEntry) bool {
+	return globMatch(m.HostName, formatHostName(e.HostName, e.Name)) &&
+		globMatch(m.ServiceGlob, e.Description) &&
+		(m.State == "" || strings.EqualFold(m.State, e.State)) &&
+		(m.StateType == "" || strings.EqualFold(m.StateType, e.StateType))
+}
+
+func (m alertMatch) matchesNotification(e nagios.NotificationListEntry) bool {
+	return globMatch(m.HostName, formatHostName(e.HostName, e.Name)) &&
+		globMatch(m.ServiceGlob, e.Description) &&
+		(m.NotificationType == "" || strings.EqualFold(m.NotificationType, e.NotificationType))
+}
+
+// matchesAlert reports whether r applies to e: its match fields all match
+// and e's state is at least as severe as r.MinSeverity.
+func (r alertRoute) matchesAlert(e nagios.AlertListEntry) bool {
+	return r.Match.matchesAlert(e) && meetsMinSeverity(e.State, r.MinSeverity)
+}
+
+// matchesNotification reports whether r applies to e. MinSeverity is
+// ignored: notifications carry no severity of their own.
+func (r alertRoute) matchesNotification(e nagios.NotificationListEntry) bool {
+	return r.Match.matchesNotification(e)
+}
+
+// routeAlert evaluates e against every stored route and posts it to each
+// channel whose route matches. It also unconditionally gives e a chance to
+// open an incident ticket (see maybeCreateIncident): that doesn't depend on
+// any route matching, since routing is opt-in and most alerts never hit a
+// configured rule. It's meant to be called from the periodic report path
+// alongside formatAlertListEntry.
+func (p *Plugin) routeAlert(e nagios.AlertListEntry) {
+	p.maybeCreateIncident(e)
+
+	routes, err := getAlertRoutes(p.API)
+	if err != nil {
+		p.API.LogError("getAlertRoutes", logErrorKey, err)
+		return
+	}
+
+	for _, r := range routes {
+		if r.matchesAlert(e) {
+			p.postToChannel(r.ChannelID, formatAlertListEntry(e))
+		}
+	}
+}
+
+// routeNotification evaluates e against every stored route and posts it to
+// each channel whose route matches. It's meant to be called from the
+// periodic report path alongside formatNotificationListEntry.
+func (p *Plugin) routeNotification(e nagios.NotificationListEntry) {
+	routes, err := getAlertRoutes(p.API)
+	if err != nil {
+		p.API.LogError("getAlertRoutes", logErrorKey, err)
+		return
+	}
+
+	for _, r := range routes {
+		if r.matchesNotification(e) {
+			p.postToChannel(r.ChannelID, formatNotificationListEntry(e))
+		}
+	}
+}
+
+func (p *Plugin) postToChannel(channelID, message string) {
+	post := &model.Post{
+		ChannelId: channelID,
+		Message:   message,
+	}
+
+	if _, err := p.API.CreatePost(post); err != nil {
+		p.API.LogError("CreatePost", logErrorKey, err)
+	}
+}
+
+// Cheat sheet:
+//
+// [command] [action] [parameters...]
+// route     add       <channelID> <key>=<value>...
+// route     list
+// route     remove    <index>
+//
+// Recognized keys for `route add`: host, service, state, stateType,
+// notificationType, minSeverity.
+
+func parseRouteMatch(parameters []string) (alertMatch, string, error) {
+	var match alertMatch
+	var minSeverity string
+
+	for _, parameter := range parameters {
+		kv := strings.SplitN(parameter, "=", 2)
+		if len(kv) != 2 {
+			return alertMatch{}, "", fmt.Errorf("invalid key=value pair (%s)", parameter)
+		}
+
+		switch kv[0] {
+		case "host":
+			match.HostName = kv[1]
+		case "service":
+			match.ServiceGlob = kv[1]
+		case "state":
+			match.State = kv[1]
+		case "stateType":
+			match.StateType = kv[1]
+		case "notificationType":
+			match.NotificationType = kv[1]
+		case "minSeverity":
+			minSeverity = kv[1]
+		default:
+			return alertMatch{}, "", fmt.Errorf("unknown match field (%s)", kv[0])
+		}
+	}
+
+	return match, minSeverity, nil
+}
+
+func (p *Plugin) routeAdd(parameters []string) string {
+	if len(parameters) < 2 {
+		return "You must supply a target channel ID and at least one key=value match parameter."
+	}
+
+	match, minSeverity, err := parseRouteMatch(parameters[1:])
+	if err != nil {
+		p.API.LogError("parseRouteMatch", logErrorKey, err)
+		return settingAlertRouteUnsuccessful
+	}
+
+	routes, err := getAlertRoutes(p.API)
+	if err != nil {
+		p.API.LogError("getAlertRoutes", logErrorKey, err)
+		return settingAlertRouteUnsuccessful
+	}
+
+	routes = append(routes, alertRoute{
+		Match:       match,
+		ChannelID:   parameters[0],
+		MinSeverity: minSeverity,
+	})
+
+	if err := setAlertRoutes(p.API, routes); err != nil {
+		p.API.LogError("setAlertRoutes", logErrorKey, err)
+		return settingAlertRouteUnsuccessful
+	}
+
+	return "Route added successfully."
+}
+
+func (p *Plugin) routeList() string {
+	routes, err := getAlertRoutes(p.API)
+	if err != nil {
+		p.API.LogError("getAlertRoutes", logErrorKey, err)
+		return "Getting alert routes unsuccessful."
+	}
+
+	if len(routes) == 0 {
+		return "No alert routes configured."
+	}
+
+	var b strings.Builder
+
+	for i, r := range routes {
+		if i > 0 {
+			b.WriteRune('\n')
+		}
+		fmt.Fprintf(&b, "%d: %+v -> %s (minSeverity=%s)", i, r.Match, r.ChannelID, r.MinSeverity)
+	}
+
+	return b.String()
+}
+
+func (p *Plugin) routeRemove(parameters []string) string {
+	if len(parameters) != 1 {
+		return "You must supply exactly one parameter (route index, see `route list`)."
+	}
+
+	i, err := strconv.Atoi(parameters[0])
+	if err != nil {
+		p.API.LogError("Atoi", logErrorKey, err)
+		return "Invalid argument - route index must be an integer."
+	}
+
+	routes, err := getAlertRoutes(p.API)
+	if err != nil {
+		p.API.LogError("getAlertRoutes", logErrorKey, err)
+		return "Removing alert route unsuccessful."
+	}
+
+	if i < 0 || i >= len(routes) {
+		return "Invalid argument - no route with that index."
+	}
+
+	routes = append(routes[:i], routes[i+1:]...)
+
+	if err := setAlertRoutes(p.API, routes); err != nil {
+		p.API.LogError("setAlertRoutes", logErrorKey, err)
+		return "Removing alert route unsuccessful."
+	}
+
+	return "Route removed successfully."
+}
+
+func (p *Plugin) route(parameters []string) string {
+	if len(parameters) == 0 {
+		return "You must supply at least one parameter (add|list|remove)."
+	}
+
+	switch parameters[0] {
+	case "add":
+		return p.routeAdd(parameters[1:])
+	case "list":
+		return p.routeList()
+	case "remove":
+		return p.routeRemove(parameters[1:])
+	default:
+		return unknownParameterMessage(parameters[0])
+	}
+}
+
+func route(p *Plugin, channelID string, parameters []string) string {
+	return p.route(parameters)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// setting is a single registration site for a KV-backed setting: its key,
+// how to parse a raw command-line argument into the stored value, how to
+// format that value for display, its default, and the command used to
+// change it. Adding a new setting to settingsRegistry is enough for it to
+// show up in `get-settings` - no copy-pasted getter/setter pair required.
+type setting struct {
+	key        string
+	command    string
+	defaultVal interface{}
+	parse      func(string) (interface{}, error)
+	format     func(interface{}) string
+}
+
+// current returns the setting's stored value, decoded as the same type as
+// defaultVal, or defaultVal itself if it was never set.
+func (s setting) current(api plugin.API) (interface{}, error) {
+	b, err := api.KVGet(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("api.KVGet: %w", err)
+	}
+
+	if b == nil {
+		return s.defaultVal, nil
+	}
+
+	out := reflect.New(reflect.TypeOf(s.defaultVal))
+
+	if err := json.Unmarshal(b, out.Interface()); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return out.Elem().Interface(), nil
+}
+
+// set parses raw and persists it under the setting's key.
+func (s setting) set(api plugin.API, raw string) error {
+	v, err := s.parse(raw)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := api.KVSet(s.key, b); err != nil {
+		return fmt.Errorf("api.KVSet: %w", err)
+	}
+
+	return nil
+}
+
+func parsePositiveInt(raw string) (interface{}, error) {
+	i, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("strconv.Atoi: %w", err)
+	}
+	if i <= 0 {
+		return nil, fmt.Errorf("must be a positive integer")
+	}
+	return i, nil
+}
+
+func parsePositiveInt64(raw string) (interface{}, error) {
+	i, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("strconv.ParseInt: %w", err)
+	}
+	if i <= 0 {
+		return nil, fmt.Errorf("must be a positive integer")
+	}
+	return i, nil
+}
+
+func formatInt(v interface{}) string {
+	return fmt.Sprintf("%d", v)
+}
+
+func formatSeconds(v interface{}) string {
+	return fmt.Sprintf("%ds", v)
+}
+
+func formatMinutes(v interface{}) string {
+	return fmt.Sprintf("%dm", v)
+}
+
+func formatChannelList(v interface{}) string {
+	channelIDs, ok := v.([]string)
+	if !ok || len(channelIDs) == 0 {
+		return "(none)"
+	}
+	return strings.Join(channelIDs, ", ")
+}
+
+func formatRouteCount(v interface{}) string {
+	routes, ok := v.([]alertRoute)
+	if !ok || len(routes) == 0 {
+		return "(none)"
+	}
+	return fmt.Sprintf("%d route(s)", len(routes))
+}
+
+func formatString(v interface{}) string {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return "(not set)"
+	}
+	return s
+}
+
+// formatIncidentBackend reports which incident backend is configured without
+// ever showing a stored secret (JiraToken, GitHubToken, WebhookURL).
+func formatIncidentBackend(v interface{}) string {
+	cfg, ok := v.(incidentBackendConfig)
+	if !ok || cfg.Type == "" {
+		return "(not configured)"
+	}
+	return fmt.Sprintf("configured: %s", cfg.Type)
+}
+
+var (
+	logsLimitSetting = setting{
+		key:        logsLimitKey,
+		command:    "set-logs-limit <n>",
+		defaultVal: defaultLogsLimit,
+		parse:      parsePositiveInt,
+		format:     formatInt,
+	}
+	logsStartTimeSetting = setting{
+		key:        logsStartTimeKey,
+		command:    "set-logs-start-time <seconds>",
+		defaultVal: int64(defaultLogsStartTime),
+		parse:      parsePositiveInt64,
+		format:     formatSeconds,
+	}
+	reportFrequencySetting = setting{
+		key:        reportFrequencyKey,
+		command:    "set-report-frequency <minutes>",
+		defaultVal: defaultReportFrequency,
+		parse:      parsePositiveInt,
+		format:     formatMinutes,
+	}
+	reportChannelsSetting = setting{
+		key:        reportChannelKey,
+		command:    "subscribe report",
+		defaultVal: []string{},
+		format:     formatChannelList,
+	}
+	changesChannelsSetting = setting{
+		key:        changesChannelKey,
+		command:    "subscribe configuration-changes",
+		defaultVal: []string{},
+		format:     formatChannelList,
+	}
+	queryTimeoutSetting = setting{
+		key:        queryTimeoutKey,
+		command:    "set-query-timeout <seconds>",
+		defaultVal: defaultQueryTimeout,
+		parse:      parsePositiveInt,
+		format:     formatSeconds,
+	}
+	connectTimeoutSetting = setting{
+		key:        connectTimeoutKey,
+		command:    "set-connect-timeout <seconds>",
+		defaultVal: defaultConnectTimeout,
+		parse:      parsePositiveInt,
+		format:     formatSeconds,
+	}
+	alertRoutesSetting = setting{
+		key:        alertRoutesKey,
+		command:    "route add <channelID> <key>=<value>...",
+		defaultVal: []alertRoute{},
+		format:     formatRouteCount,
+	}
+	cgiURLSetting = setting{
+		key:        cgiURLKey,
+		command:    "set-cgi-url <url>",
+		defaultVal: "",
+		parse:      func(raw string) (interface{}, error) { return raw, nil },
+		format:     formatString,
+	}
+	incidentBackendSetting = setting{
+		key:        incidentBackendsKey,
+		command:    "incident configure <jira|github|webhook> <key>=<value>...",
+		defaultVal: incidentBackendConfig{},
+		format:     formatIncidentBackend,
+	}
+)
+
+// settingsRegistry lists every KV-backed setting this plugin knows about.
+// `get-settings` walks it to render the current configuration.
+var settingsRegistry = []setting{
+	logsLimitSetting,
+	logsStartTimeSetting,
+	reportFrequencySetting,
+	reportChannelsSetting,
+	changesChannelsSetting,
+	queryTimeoutSetting,
+	connectTimeoutSetting,
+	alertRoutesSetting,
+	cgiURLSetting,
+	incidentBackendSetting,
+}
+
+// getSettings renders every registered setting as a Markdown table, with its
+// current value, default value, and the command used to change it. It's
+// posted ephemerally, same as every other command response in this file.
+func (p *Plugin) getSettings() string {
+	var b strings.Builder
+
+	b.WriteString("| Setting | Current | Default | Set with |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+
+	for _, s := range settingsRegistry {
+		current, err := s.current(p.API)
+		if err != nil {
+			p.API.LogError("current", logErrorKey, err)
+			current = s.defaultVal
+		}
+
+		fmt.Fprintf(&b, "| %s | %s | %s | `/nagios %s` |\n",
+			s.key, s.format(current), s.format(s.defaultVal), s.command)
+	}
+
+	return b.String()
+}
+
+func getSettings(p *Plugin, channelID string, parameters []string) string {
+	return p.getSettings()
+}
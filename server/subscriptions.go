@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// reportChannelKey and changesChannelKey now each hold a JSON array of
+// channel IDs rather than a single channel ID, so that more than one channel
+// can subscribe to the same kind of notification.
+
+func getReportChannels(api plugin.API) ([]string, error) {
+	return getChannelSet(api, reportChannelKey)
+}
+
+func getChangesChannels(api plugin.API) ([]string, error) {
+	return getChannelSet(api, changesChannelKey)
+}
+
+func getChannelSet(api plugin.API, key string) ([]string, error) {
+	b, err := api.KVGet(key)
+	if err != nil {
+		return nil, fmt.Errorf("api.KVGet: %w", err)
+	}
+
+	return unmarshalChannelSet(b)
+}
+
+func unmarshalChannelSet(b []byte) ([]string, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	var channelIDs []string
+	if err := json.Unmarshal(b, &channelIDs); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return channelIDs, nil
+}
+
+// addChannelToSet and removeChannelFromSet write with KVCompareAndSet, as
+// leaderElection.tryAcquire does for the leader lock, rather than a plain
+// KVGet/KVSet, and start over whenever the stored set changed in between:
+// two nodes editing the same channel set at once would otherwise race and
+// one edit would silently overwrite the other.
+
+func addChannelToSet(api plugin.API, key, channelID string) error {
+	for {
+		old, err := api.KVGet(key)
+		if err != nil {
+			return fmt.Errorf("api.KVGet: %w", err)
+		}
+
+		channelIDs, err := unmarshalChannelSet(old)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range channelIDs {
+			if id == channelID {
+				return nil
+			}
+		}
+		channelIDs = append(channelIDs, channelID)
+
+		b, err := json.Marshal(channelIDs)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %w", err)
+		}
+
+		ok, err := api.KVCompareAndSet(key, old, b)
+		if err != nil {
+			return fmt.Errorf("api.KVCompareAndSet: %w", err)
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+func removeChannelFromSet(api plugin.API, key, channelID string) error {
+	for {
+		old, err := api.KVGet(key)
+		if err != nil {
+			return fmt.Errorf("api.KVGet: %w", err)
+		}
+
+		channelIDs, err := unmarshalChannelSet(old)
+		if err != nil {
+			return err
+		}
+
+		filtered := channelIDs[:0]
+		for _, id := range channelIDs {
+			if id != channelID {
+				filtered = append(filtered, id)
+			}
+		}
+
+		b, err := json.Marshal(filtered)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %w", err)
+		}
+
+		ok, err := api.KVCompareAndSet(key, old, b)
+		if err != nil {
+			return fmt.Errorf("api.KVCompareAndSet: %w", err)
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// subscriptionManager owns the in-memory state that can't be persisted to
+// the KV store: the report tickers currently running on this node. It is
+// reconciled against the KV store every time this node confirms cluster
+// leadership (including renewals, not just on acquiring it), and torn down
+// entirely when it loses it. Reconciling on every renewal, rather than only
+// on a leadership change, is what lets a subscribe/unsubscribe handled by a
+// follower node take effect on the leader without waiting for leadership to
+// change hands.
+type subscriptionManager struct {
+	mu      sync.Mutex
+	tickers map[string]chan bool
+}
+
+var subs = &subscriptionManager{tickers: make(map[string]chan bool)}
+
+func (m *subscriptionManager) start(p *Plugin, channelID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, running := m.tickers[channelID]; running {
+		return
+	}
+
+	stop := make(chan bool, 1)
+	m.tickers[channelID] = stop
+
+	go p.addMonitoringReport(channelID, stop)
+}
+
+func (m *subscriptionManager) stop(channelID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stop, running := m.tickers[channelID]
+	if !running {
+		return
+	}
+
+	stop <- true
+	delete(m.tickers, channelID)
+}
+
+func (m *subscriptionManager) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for channelID, stop := range m.tickers {
+		stop <- true
+		delete(m.tickers, channelID)
+	}
+}
+
+func (m *subscriptionManager) running() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	channelIDs := make([]string, 0, len(m.tickers))
+	for channelID := range m.tickers {
+		channelIDs = append(channelIDs, channelID)
+	}
+
+	return channelIDs
+}
+
+// rebuild starts a ticker for every channel subscribed to reports that isn't
+// already running on this node, and stops any ticker running for a channel
+// that's no longer subscribed. It's called every time this node confirms
+// cluster leadership, so it also catches a subscribe/unsubscribe that a
+// follower node handled since the last call.
+func (m *subscriptionManager) rebuild(p *Plugin) {
+	channelIDs, err := getReportChannels(p.API)
+	if err != nil {
+		p.API.LogError("getReportChannels", logErrorKey, err)
+		return
+	}
+
+	desired := make(map[string]bool, len(channelIDs))
+	for _, channelID := range channelIDs {
+		desired[channelID] = true
+		m.start(p, channelID)
+	}
+
+	for _, channelID := range m.running() {
+		if !desired[channelID] {
+			m.stop(channelID)
+		}
+	}
+}
+
+var (
+	leOnce sync.Once
+	le     *leaderElection
+)
+
+// ensureLeaderElection lazily starts the cluster leader election loop for
+// this node the first time it's needed (the first `subscribe report` or
+// `status` command), reconciling the local ticker set against the KV store every
+// time this node confirms leadership.
+func ensureLeaderElection(p *Plugin) *leaderElection {
+	leOnce.Do(func() {
+		le = newLeaderElection(p.API,
+			func() { subs.rebuild(p) },
+			func() { subs.stopAll() },
+		)
+		le.run()
+	})
+	return le
+}
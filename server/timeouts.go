@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/ulumuri/go-nagios/nagios"
+)
+
+const (
+	settingQueryTimeoutUnsuccessful = "Setting query timeout unsuccessful."
+	queryTimeoutKey                 = "nagios-query-timeout"
+	defaultQueryTimeout             = 10 // seconds
+
+	settingConnectTimeoutUnsuccessful = "Setting connect timeout unsuccessful."
+	connectTimeoutKey                 = "nagios-connect-timeout"
+	defaultConnectTimeout             = 5 // seconds
+
+	gettingLogsTimedOut  = "Getting logs unsuccessful: the query timed out."
+	gettingLogsCancelled = "Getting logs unsuccessful: the query was cancelled."
+)
+
+func getQueryTimeout(api plugin.API) (time.Duration, error) {
+	v, err := queryTimeoutSetting.current(api)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(v.(int)) * time.Second, nil
+}
+
+func (p *Plugin) setQueryTimeout(parameters []string) string {
+	if len(parameters) != 1 {
+		return "You must supply exactly one parameter (number of seconds)."
+	}
+
+	if err := queryTimeoutSetting.set(p.API, parameters[0]); err != nil {
+		p.API.LogError("set", logErrorKey, err)
+		return settingQueryTimeoutUnsuccessful
+	}
+
+	return "Query timeout set successfully."
+}
+
+func setQueryTimeout(p *Plugin, channelID string, parameters []string) string {
+	return p.setQueryTimeout(parameters)
+}
+
+func getConnectTimeout(api plugin.API) (time.Duration, error) {
+	v, err := connectTimeoutSetting.current(api)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(v.(int)) * time.Second, nil
+}
+
+func (p *Plugin) setConnectTimeout(parameters []string) string {
+	if len(parameters) != 1 {
+		return "You must supply exactly one parameter (number of seconds)."
+	}
+
+	if err := connectTimeoutSetting.set(p.API, parameters[0]); err != nil {
+		p.API.LogError("set", logErrorKey, err)
+		return settingConnectTimeoutUnsuccessful
+	}
+
+	return "Connect timeout set successfully."
+}
+
+func setConnectTimeout(p *Plugin, channelID string, parameters []string) string {
+	return p.setConnectTimeout(parameters)
+}
+
+// queryCanceller lets an admin abort every in-flight Nagios query via
+// `/nagios cancel-queries`. Each query waits on the channel returned by
+// subscribe; cancelAll closes it to broadcast to every waiter and replaces
+// it so later queries aren't cancelled by a stale broadcast.
+type queryCanceller struct {
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+var canceller = &queryCanceller{stop: make(chan struct{})}
+
+func (c *queryCanceller) subscribe() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stop
+}
+
+func (c *queryCanceller) cancelAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.stop)
+	c.stop = make(chan struct{})
+}
+
+func (p *Plugin) cancelQueries() string {
+	canceller.cancelAll()
+	return "Cancelled all in-flight queries."
+}
+
+func cancelQueries(p *Plugin, channelID string, parameters []string) string {
+	return p.cancelQueries()
+}
+
+// errTimedOut and errCancelled let query callers tell a deadline exceeded
+// apart from an admin-issued cancellation, as opposed to a Nagios-side
+// error, which is returned unwrapped from the query itself.
+var (
+	errTimedOut  = fmt.Errorf("query timed out")
+	errCancelled = fmt.Errorf("query cancelled")
+)
+
+// query runs q against p.client with a deadline modeled on net.Conn-style
+// read/write deadlines: connectTimeout plus queryTimeout bounds how long the
+// whole call may take, and an admin-issued `/nagios cancel-queries` can abort
+// it early. ctx is passed into p.client.Query so the underlying HTTP request
+// (built with http.NewRequestWithContext) is itself aborted on timeout or
+// cancellation, rather than merely abandoned on its own goroutine.
+func (p *Plugin) query(q nagios.Query, v interface{}) error {
+	queryTimeout, err := getQueryTimeout(p.API)
+	if err != nil {
+		return fmt.Errorf("getQueryTimeout: %w", err)
+	}
+
+	connectTimeout, err := getConnectTimeout(p.API)
+	if err != nil {
+		return fmt.Errorf("getConnectTimeout: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout+queryTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- p.client.Query(ctx, q, v) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errTimedOut
+	case <-canceller.subscribe():
+		cancel()
+		return errCancelled
+	}
+}